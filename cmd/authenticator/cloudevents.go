@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/Guillaume-Boutry/grpc-backend/pkg/face_authenticator"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// Receiver is the CloudEvents front end: a thin adapter that unwraps the
+// inbound event, delegates to the shared Authenticator core, and wraps the
+// result back into a CloudEvent.
+type Receiver struct {
+	service *Authenticator
+}
+
+type Message struct {
+	Payload []byte `json:"payload"`
+}
+
+// Request is the structure of the event we expect to receive.
+type Request struct {
+	Id         string `json:"id"`
+	Embeddings string `json:"embeddings,omitempty"`
+}
+
+// Response is the structure of the event we send in response to requests.
+type Response struct {
+	Id         string `json:"id"`
+	Message    string `json:"message,omitempty"`
+	Embeddings string `json:"embeddings,omitempty"`
+}
+
+// ReceiveAndReply is invoked whenever we receive an event.
+func (recv *Receiver) ReceiveAndReply(ctx context.Context, event cloudevents.Event) (*cloudevents.Event, cloudevents.Result) {
+	req := Message{}
+	if err := event.DataAs(&req); err != nil {
+		log.Println(err)
+		return nil, cloudevents.NewHTTPResult(400, "failed to convert data: %s", err)
+	}
+
+	authenticatRequest := &face_authenticator.AuthenticateRequest{}
+	if err := proto.Unmarshal(req.Payload, authenticatRequest); err != nil {
+		log.Println(err)
+		return nil, cloudevents.NewHTTPResult(500, "failed to deserialize protobuf")
+	}
+
+	authenticateResponse, err := recv.service.Authenticate(ctx, authenticatRequest)
+	if err != nil {
+		log.Println(err)
+		return nil, httpResultFor(err)
+	}
+
+	resp, err := proto.Marshal(authenticateResponse)
+	if err != nil {
+		log.Println(err)
+		return nil, cloudevents.NewHTTPResult(500, "failed to serialize response")
+	}
+	r := cloudevents.NewEvent(cloudevents.VersionV1)
+	r.SetType("authenticate-response")
+	r.SetSource("authenticator")
+	msg := Message{Payload: resp}
+	if err := r.SetData("application/json", msg); err != nil {
+		return nil, cloudevents.NewHTTPResult(500, "failed to set response data")
+	}
+
+	return &r, nil
+}
+
+// httpResultFor maps an Authenticate error to the HTTP status returned to the
+// CloudEvents client: 429 when the worker pool is saturated, 408 when our own
+// deadline elapsed, 503 when the caller went away or the process is shutting
+// down, 500 for anything else.
+func httpResultFor(err error) cloudevents.Result {
+	switch {
+	case errors.Is(err, errPoolSaturated):
+		return cloudevents.NewHTTPResult(429, "worker pool is saturated, try again later")
+	case errors.Is(err, context.DeadlineExceeded):
+		return cloudevents.NewHTTPResult(408, "request timed out")
+	case errors.Is(err, context.Canceled):
+		return cloudevents.NewHTTPResult(503, "request cancelled")
+	default:
+		return cloudevents.NewHTTPResult(500, "%s", err)
+	}
+}
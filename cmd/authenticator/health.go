@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// sinkProbeTTL is how long a K_SINK reachability check is trusted before a
+// fresh dial is attempted.
+const sinkProbeTTL = 10 * time.Second
+
+// Health tracks worker-pool readiness and a cached K_SINK reachability probe,
+// and serves /healthz, /readyz and /metrics for it.
+type Health struct {
+	mu      sync.RWMutex
+	workers map[int]bool
+
+	// minReady is the number of ready workers /readyz requires. It's fixed at
+	// the pool's starting size, so a burst that grows the pool past that
+	// doesn't pull an already-serving pod out of rotation while the new
+	// worker's model load is still in flight.
+	minReady int
+
+	readyCh   chan struct{}
+	readyOnce sync.Once
+
+	sinkTarget    string
+	lastSinkCheck time.Time
+	lastSinkOK    bool
+}
+
+// newHealth creates a Health tracker for workerCount workers, none of which
+// are ready yet. /readyz requires workerCount of them ready, regardless of
+// how many the pool later grows to.
+func newHealth(workerCount int, sinkTarget string) *Health {
+	workers := make(map[int]bool, workerCount)
+	for i := 1; i <= workerCount; i++ {
+		workers[i] = false
+	}
+	return &Health{
+		workers:    workers,
+		minReady:   workerCount,
+		readyCh:    make(chan struct{}),
+		sinkTarget: sinkTarget,
+	}
+}
+
+// registerWorker adds idThread to the tracked pool, not-ready, so that /readyz
+// lists it until it reports in. A not-ready worker doesn't lower allReady,
+// which only counts ready workers against minReady. Used when the pool grows.
+func (h *Health) registerWorker(idThread int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.workers[idThread] = false
+}
+
+// removeWorker drops idThread from the tracked pool. Used when the pool
+// shrinks a worker after an idle timeout, so /readyz neither lists nor counts
+// a worker that no longer exists.
+func (h *Health) removeWorker(idThread int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.workers, idThread)
+}
+
+// markReady records that worker idThread has finished loading its models and
+// is ready to serve jobs. The first call unblocks waitForAnyReady.
+func (h *Health) markReady(idThread int) {
+	h.mu.Lock()
+	h.workers[idThread] = true
+	h.mu.Unlock()
+	h.readyOnce.Do(func() { close(h.readyCh) })
+}
+
+// waitForAnyReady returns a channel closed once the first worker reports
+// ready.
+func (h *Health) waitForAnyReady() <-chan struct{} {
+	return h.readyCh
+}
+
+// allReady reports whether at least minReady workers have reported ready.
+// It deliberately doesn't require *every* tracked worker: once the pool has
+// grown past its starting size to absorb a traffic burst, the pod is already
+// serving and shouldn't be pulled out of rotation just because the newest
+// worker is still loading its models.
+func (h *Health) allReady() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ready := 0
+	for _, r := range h.workers {
+		if r {
+			ready++
+		}
+	}
+	return ready >= h.minReady
+}
+
+// snapshot returns a copy of the per-worker readiness map, for /readyz.
+func (h *Health) snapshot() map[int]bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make(map[int]bool, len(h.workers))
+	for id, ready := range h.workers {
+		out[id] = ready
+	}
+	return out
+}
+
+// sinkReachable reports whether K_SINK can be dialed, caching the result for
+// sinkProbeTTL so /readyz doesn't dial out on every Kubernetes probe.
+func (h *Health) sinkReachable() bool {
+	if h.sinkTarget == "" {
+		return true
+	}
+
+	h.mu.RLock()
+	fresh := time.Since(h.lastSinkCheck) < sinkProbeTTL
+	ok := h.lastSinkOK
+	h.mu.RUnlock()
+	if fresh {
+		return ok
+	}
+
+	ok = dialSink(h.sinkTarget)
+	h.mu.Lock()
+	h.lastSinkOK = ok
+	h.lastSinkCheck = time.Now()
+	h.mu.Unlock()
+	return ok
+}
+
+func dialSink(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, 2*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+type readyzResponse struct {
+	Ready   bool         `json:"ready"`
+	Workers map[int]bool `json:"workers"`
+	Sink    bool         `json:"sink"`
+}
+
+// startHealthServer starts the health/readiness/metrics HTTP mux on port. It
+// runs until the process exits, so callers should invoke it in a goroutine.
+func startHealthServer(health *Health, port string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		resp := readyzResponse{
+			Workers: health.snapshot(),
+			Sink:    health.sinkReachable(),
+		}
+		resp.Ready = health.allReady() && resp.Sink
+		w.Header().Set("Content-Type", "application/json")
+		if !resp.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Println(err)
+		}
+	})
+	mux.Handle("/metrics", metricsHandler())
+
+	log.Printf("Health server listening on :%s\n", port)
+	return http.ListenAndServe(":"+port, mux)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// workerJobsTotal is aggregated across the whole pool, not per worker id:
+	// spawnWorker mints a fresh, never-reused id on every grow, so a
+	// per-worker label would grow Prometheus's label cardinality without
+	// bound on a pod that grows and shrinks repeatedly.
+	workerJobsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "authenticator_worker_jobs_total",
+		Help: "Number of face-authentication jobs processed.",
+	})
+
+	scoringLatencySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "authenticator_scoring_latency_seconds",
+		Help:    "Time spent computing the distance between submitted and reference embeddings.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	decisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "authenticator_decisions_total",
+		Help: "Authentication decisions, labelled accept/reject.",
+	}, []string{"decision"})
+
+	sinkLookupLatencySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "authenticator_sink_lookup_latency_seconds",
+		Help:    "Time spent fetching reference embeddings from K_SINK.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	poolSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "authenticator_worker_pool_size",
+		Help: "Current number of workers in the pool.",
+	})
+
+	queueWaitSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "authenticator_queue_wait_seconds",
+		Help:    "Time a job spent queued before a worker picked it up.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	queueRejectedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "authenticator_queue_rejected_total",
+		Help: "Requests rejected with backpressure because the worker pool queue was full.",
+	})
+)
+
+// metricsHandler exposes the registered metrics for /metrics.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+func recordJob() {
+	workerJobsTotal.Inc()
+}
+
+func recordDecision(decision bool) {
+	label := "reject"
+	if decision {
+		label = "accept"
+	}
+	decisionsTotal.WithLabelValues(label).Inc()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	authenticator "github.com/Guillaume-Boutry/face-authenticator-wrapper"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// errPoolSaturated is returned by WorkerPool.Enqueue when the queue is full;
+// transports map it to a backpressure response (HTTP 429, gRPC
+// ResourceExhausted) instead of blocking the caller.
+var errPoolSaturated = errors.New("worker pool saturated")
+
+// defaultMonitorInterval is the monitor's tick length and defaultGrowWindow
+// how many ticks the sliding queue-depth average is taken over before the
+// pool is allowed to grow. Both are defaults for fields on PoolConfig, so
+// tests can shrink them instead of waiting out real time.
+const (
+	defaultMonitorInterval = 500 * time.Millisecond
+	defaultGrowWindow      = 6
+)
+
+// PoolConfig tunes the worker pool's size and backpressure behaviour.
+type PoolConfig struct {
+	MinWorkers      int
+	MaxWorkers      int
+	QueueDepth      int
+	IdleTimeout     time.Duration
+	MonitorInterval time.Duration
+	GrowWindow      int
+}
+
+// loadPoolConfig derives defaults from runtime.NumCPU(), then applies any
+// WORKER_COUNT/MAX_WORKER_COUNT/QUEUE_DEPTH/WORKER_IDLE_TIMEOUT overrides.
+// envconfig can't express "default to NumCPU()", so the defaulting happens
+// here instead of via struct tags.
+func loadPoolConfig() PoolConfig {
+	cpu := runtime.NumCPU()
+	cfg := PoolConfig{
+		MinWorkers:      cpu,
+		MaxWorkers:      cpu * 4,
+		QueueDepth:      cpu * 8,
+		IdleTimeout:     60 * time.Second,
+		MonitorInterval: defaultMonitorInterval,
+		GrowWindow:      defaultGrowWindow,
+	}
+
+	var overrides struct {
+		MinWorkers  int           `envconfig:"WORKER_COUNT"`
+		MaxWorkers  int           `envconfig:"MAX_WORKER_COUNT"`
+		QueueDepth  int           `envconfig:"QUEUE_DEPTH"`
+		IdleTimeout time.Duration `envconfig:"WORKER_IDLE_TIMEOUT"`
+	}
+	if err := envconfig.Process("", &overrides); err != nil {
+		log.Fatal(err.Error())
+	}
+	if overrides.MinWorkers > 0 {
+		cfg.MinWorkers = overrides.MinWorkers
+	}
+	if overrides.MaxWorkers > 0 {
+		cfg.MaxWorkers = overrides.MaxWorkers
+	}
+	if overrides.QueueDepth > 0 {
+		cfg.QueueDepth = overrides.QueueDepth
+	}
+	if overrides.IdleTimeout > 0 {
+		cfg.IdleTimeout = overrides.IdleTimeout
+	}
+	if cfg.MaxWorkers < cfg.MinWorkers {
+		cfg.MaxWorkers = cfg.MinWorkers
+	}
+	return cfg
+}
+
+// queuedWork is a work item plus the time it was accepted onto the queue, so
+// workers can report wait time and drop jobs whose deadline has already
+// passed by the time they're picked up.
+type queuedWork struct {
+	*work
+	enqueuedAt time.Time
+}
+
+// jobHandler is what a pool worker uses to process one job once its
+// model-load cost has already been paid. Production workers load the dlib
+// models once in newHandler and reuse them across jobs; tests can inject a
+// lightweight stub to exercise pool admission control and grow/shrink
+// without touching dlib.
+type jobHandler struct {
+	process func(w *work)
+	close   func()
+}
+
+// WorkerPool is a bounded, backpressure-aware pool of dlib workers. At most
+// cfg.QueueDepth jobs may be queued or in flight at once; beyond that, Enqueue
+// fails immediately instead of blocking. Workers lazily grow from MinWorkers
+// towards MaxWorkers as sustained queue depth demands it, and shrink back
+// down after sitting idle for cfg.IdleTimeout.
+type WorkerPool struct {
+	cfg        PoolConfig
+	jobs       chan *queuedWork
+	sem        chan struct{}
+	health     *Health
+	newHandler func(id int) jobHandler
+
+	mu     sync.Mutex
+	size   int
+	nextID int
+}
+
+// newWorkerPool builds a pool at cfg.MinWorkers and starts its grow monitor;
+// shrinking is left to each worker's idle timer. newHandler is called once per worker goroutine, after which the
+// pool calls the returned jobHandler.process for every job that worker picks
+// up.
+func newWorkerPool(cfg PoolConfig, health *Health, newHandler func(id int) jobHandler) *WorkerPool {
+	if cfg.MonitorInterval <= 0 {
+		cfg.MonitorInterval = defaultMonitorInterval
+	}
+	if cfg.GrowWindow <= 0 {
+		cfg.GrowWindow = defaultGrowWindow
+	}
+	p := &WorkerPool{
+		cfg:        cfg,
+		jobs:       make(chan *queuedWork, cfg.QueueDepth),
+		sem:        make(chan struct{}, cfg.QueueDepth),
+		health:     health,
+		newHandler: newHandler,
+	}
+	for i := 0; i < cfg.MinWorkers; i++ {
+		p.spawnWorker()
+	}
+	go p.monitor()
+	return p
+}
+
+// newDlibHandler loads the dlib models once for worker idThread and returns a
+// handler that reuses them for every job it processes.
+func newDlibHandler(idThread int) jobHandler {
+	authent := authenticator.NewAuthenticator(32)
+	log.Printf("Thread %d: Init authenticator\n", idThread)
+	modelDir, pres := os.LookupEnv("model_dir")
+	if !pres {
+		modelDir = "/opt/authenticator"
+	}
+	authent.Init(filepath.Join(modelDir, "shape_predictor_5_face_landmarks.dat"), filepath.Join(modelDir, "dlib_face_recognition_resnet_model_v1.dat"))
+	log.Printf("Thread %d: Ready to authenticate\n", idThread)
+	return jobHandler{
+		process: func(w *work) { generateEmbeddings(&authent, w, idThread) },
+		close:   func() { authenticator.DeleteAuthenticator(authent) },
+	}
+}
+
+// Enqueue submits w to the pool. It reserves a slot out of cfg.QueueDepth
+// covering both queued and in-flight jobs and returns false immediately,
+// rather than blocking, when the pool is saturated.
+func (p *WorkerPool) Enqueue(w *work) bool {
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		queueRejectedTotal.Inc()
+		return false
+	}
+	select {
+	case p.jobs <- &queuedWork{work: w, enqueuedAt: time.Now()}:
+		return true
+	default:
+		<-p.sem
+		queueRejectedTotal.Inc()
+		return false
+	}
+}
+
+func (p *WorkerPool) currentSize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// tryShrink releases one worker slot if the pool is above MinWorkers.
+func (p *WorkerPool) tryShrink() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size <= p.cfg.MinWorkers {
+		return false
+	}
+	p.size--
+	return true
+}
+
+func (p *WorkerPool) spawnWorker() {
+	p.mu.Lock()
+	p.nextID++
+	id := p.nextID
+	p.size++
+	p.mu.Unlock()
+
+	p.health.registerWorker(id)
+	poolSize.Set(float64(p.currentSize()))
+	go p.runWorker(id)
+}
+
+// monitor grows the pool by one worker per tick while the queue depth,
+// averaged (rounded down) over the last cfg.GrowWindow ticks, is at least one,
+// and relies on each worker's own idle timer to shrink.
+func (p *WorkerPool) monitor() {
+	samples := make([]int, 0, p.cfg.GrowWindow)
+	ticker := time.NewTicker(p.cfg.MonitorInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		samples = append(samples, len(p.jobs))
+		if len(samples) > p.cfg.GrowWindow {
+			samples = samples[1:]
+		}
+		if len(samples) < p.cfg.GrowWindow {
+			continue
+		}
+		sum := 0
+		for _, s := range samples {
+			sum += s
+		}
+		avgDepth := sum / len(samples)
+
+		size := p.currentSize()
+		if avgDepth > 0 && size < p.cfg.MaxWorkers {
+			log.Printf("Worker pool: queue depth averaging %d over the last %s, growing from %d workers\n", avgDepth, time.Duration(p.cfg.GrowWindow)*p.cfg.MonitorInterval, size)
+			p.spawnWorker()
+		}
+	}
+}
+
+func (p *WorkerPool) runWorker(id int) {
+	h := p.newHandler(id)
+	defer h.close()
+	p.health.markReady(id)
+
+	idle := time.NewTimer(p.cfg.IdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case qw, ok := <-p.jobs:
+			if !ok {
+				return
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			p.processJob(id, h, qw)
+			idle.Reset(p.cfg.IdleTimeout)
+		case <-idle.C:
+			if p.tryShrink() {
+				log.Printf("Thread %d: shutting down after %s idle\n", id, p.cfg.IdleTimeout)
+				p.health.removeWorker(id)
+				poolSize.Set(float64(p.currentSize()))
+				return
+			}
+			idle.Reset(p.cfg.IdleTimeout)
+		}
+	}
+}
+
+func (p *WorkerPool) processJob(id int, h jobHandler, qw *queuedWork) {
+	defer func() { <-p.sem }()
+	wait := time.Since(qw.enqueuedAt)
+	queueWaitSeconds.Observe(wait.Seconds())
+	if qw.work.ctx.Err() != nil {
+		log.Printf("Thread %d: dropping stale job after %s in queue: %v\n", id, wait, qw.work.ctx.Err())
+		return
+	}
+	recordJob()
+	h.process(qw.work)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) func(id int) jobHandler {
+	return func(id int) jobHandler {
+		return jobHandler{
+			process: func(w *work) {
+				if started != nil {
+					started <- struct{}{}
+				}
+				<-release
+			},
+			close: func() {},
+		}
+	}
+}
+
+func noopHandler(id int) jobHandler {
+	return jobHandler{process: func(w *work) {}, close: func() {}}
+}
+
+func waitUntil(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		if cond() {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("condition not met before timeout")
+		case <-time.After(2 * time.Millisecond):
+		}
+	}
+}
+
+// TestWorkerPoolBackpressure asserts that once QueueDepth worth of jobs are
+// queued or in flight, further Enqueue calls fail immediately (the caller is
+// expected to answer with HTTP 429) rather than blocking.
+func TestWorkerPoolBackpressure(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	health := newHealth(1, "")
+	pool := newWorkerPool(PoolConfig{
+		MinWorkers:  1,
+		MaxWorkers:  1,
+		QueueDepth:  1,
+		IdleTimeout: time.Hour,
+	}, health, blockingHandler(started, release))
+	<-health.waitForAnyReady()
+
+	if !pool.Enqueue(&work{ctx: context.Background()}) {
+		t.Fatal("expected first job to be admitted")
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("worker never picked up the first job")
+	}
+
+	if pool.Enqueue(&work{ctx: context.Background()}) {
+		t.Fatal("expected second job to be rejected while the pool is saturated")
+	}
+}
+
+// TestWorkerPoolGrowsUnderSustainedLoad asserts that the pool grows past
+// MinWorkers when the queue stays non-empty across the monitor's sliding
+// window.
+func TestWorkerPoolGrowsUnderSustainedLoad(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	health := newHealth(1, "")
+	pool := newWorkerPool(PoolConfig{
+		MinWorkers:      1,
+		MaxWorkers:      3,
+		QueueDepth:      10,
+		IdleTimeout:     time.Hour,
+		MonitorInterval: 5 * time.Millisecond,
+		GrowWindow:      3,
+	}, health, blockingHandler(nil, release))
+	<-health.waitForAnyReady()
+
+	for i := 0; i < 5; i++ {
+		if !pool.Enqueue(&work{ctx: context.Background()}) {
+			t.Fatalf("job %d unexpectedly rejected", i)
+		}
+	}
+
+	waitUntil(t, time.Second, func() bool { return pool.currentSize() > 1 })
+}
+
+// TestHealthToleratesGrowth asserts that growing the pool past its starting
+// size doesn't flip allReady back to false while the new worker is still
+// loading its models, so /readyz doesn't pull an already-serving pod out of
+// rotation during a traffic burst.
+func TestHealthToleratesGrowth(t *testing.T) {
+	health := newHealth(1, "")
+	pool := newWorkerPool(PoolConfig{
+		MinWorkers:      1,
+		MaxWorkers:      3,
+		QueueDepth:      10,
+		IdleTimeout:     time.Hour,
+		MonitorInterval: time.Hour,
+		GrowWindow:      1,
+	}, health, noopHandler)
+	<-health.waitForAnyReady()
+
+	if !health.allReady() {
+		t.Fatal("expected allReady once the starting worker is up")
+	}
+
+	pool.spawnWorker()
+	if !health.allReady() {
+		t.Fatal("expected allReady to stay true immediately after spawnWorker registers the not-yet-ready new worker")
+	}
+	waitUntil(t, time.Second, func() bool { return pool.currentSize() == 2 })
+}
+
+// TestWorkerPoolShrinksAfterIdle asserts that a worker above MinWorkers exits
+// once it has sat idle for longer than IdleTimeout.
+func TestWorkerPoolShrinksAfterIdle(t *testing.T) {
+	health := newHealth(1, "")
+	pool := newWorkerPool(PoolConfig{
+		MinWorkers:      1,
+		MaxWorkers:      3,
+		QueueDepth:      10,
+		IdleTimeout:     20 * time.Millisecond,
+		MonitorInterval: time.Hour,
+		GrowWindow:      1,
+	}, health, noopHandler)
+	<-health.waitForAnyReady()
+
+	pool.spawnWorker()
+	if got := pool.currentSize(); got != 2 {
+		t.Fatalf("expected pool size 2 after manual spawn, got %d", got)
+	}
+
+	waitUntil(t, time.Second, func() bool { return pool.currentSize() == 1 })
+}
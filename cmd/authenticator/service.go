@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	authenticator "github.com/Guillaume-Boutry/face-authenticator-wrapper"
+	"github.com/Guillaume-Boutry/grpc-backend/pkg/face_authenticator"
+	"log"
+	"math"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Alias to dlib type
+type FeatureMatrix authenticator.Dlib_matrix_Sl_float_Sc_0_Sc_1_Sg_
+
+type work struct {
+	ctx             context.Context
+	faceRequest     *face_authenticator.FaceRequest
+	responseChannel chan FeatureMatrix
+}
+
+type getResponse struct {
+	embeddings []float32
+	err        error
+}
+
+// Authenticator is the transport-agnostic core of the service: it owns the
+// dlib worker pool and the K_SINK reference-embeddings lookup, and is shared
+// by the CloudEvents and gRPC front ends.
+type Authenticator struct {
+	client cloudevents.Client
+
+	// If the K_SINK environment variable is set, then events are sent there,
+	// otherwise we simply reply to the inbound request.
+	Target    string  `envconfig:"K_SINK"`
+	Threshold float32 `envconfig:"THRESHOLD"`
+	// RequestTimeout bounds how long a single request may wait on the worker
+	// pool and on the K_SINK lookup before we give up on it.
+	RequestTimeout time.Duration `envconfig:"REQUEST_TIMEOUT" default:"5s"`
+
+	// pool runs the dlib jobs; see WorkerPool.
+	pool *WorkerPool
+}
+
+// Authenticate runs the full face-authentication pipeline for req: a worker
+// generates embeddings for the submitted face while getEmbeddings fetches
+// the stored reference embeddings, then the two are scored against each
+// other. It is the single entry point shared by the CloudEvents and gRPC
+// transports.
+func (a *Authenticator) Authenticate(ctx context.Context, req *face_authenticator.AuthenticateRequest) (*face_authenticator.AuthenticateResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.RequestTimeout)
+	defer cancel()
+
+	// Buffered by one so the goroutine/worker can hand off its result and
+	// exit even after we've already given up on ctx below.
+	resChannel := make(chan getResponse, 1)
+	go func() {
+		embeddingsRef, err := a.getEmbeddings(ctx, req.FaceRequest.Id)
+		if err != nil {
+			log.Println(err)
+		}
+		resChannel <- getResponse{
+			embeddings: embeddingsRef,
+			err:        err,
+		}
+	}()
+
+	responseChannel := make(chan FeatureMatrix, 1)
+	if !a.pool.Enqueue(&work{ctx: ctx, faceRequest: req.FaceRequest, responseChannel: responseChannel}) {
+		return nil, errPoolSaturated
+	}
+
+	var embeddingsResponse getResponse
+	select {
+	case embeddingsResponse = <-resChannel:
+	case <-ctx.Done():
+		log.Printf("request %s: %v while fetching reference embeddings", req.FaceRequest.Id, ctx.Err())
+		return nil, ctx.Err()
+	}
+
+	var embeddings FeatureMatrix
+	select {
+	case embeddings = <-responseChannel:
+	case <-ctx.Done():
+		log.Printf("request %s: %v while waiting on worker pool", req.FaceRequest.Id, ctx.Err())
+		return nil, ctx.Err()
+	}
+
+	if embeddingsResponse.err != nil {
+		return nil, fmt.Errorf("error while getting reference embeddings: %w", embeddingsResponse.err)
+	}
+	ptr := &embeddingsResponse.embeddings[0]
+	embeddingsRef := authenticator.Deserialize_embeddings(ptr)
+
+	authent := authenticator.NewAuthenticator(0)
+	defer authenticator.DeleteAuthenticator(authent)
+	scoringStart := time.Now()
+	score := float32(authent.ComputeDistance(embeddings, embeddingsRef))
+	scoringLatencySeconds.Observe(time.Since(scoringStart).Seconds())
+	fmt.Printf("Score %f\n", score)
+	decision := score < a.Threshold
+	recordDecision(decision)
+	authenticateResponse := &face_authenticator.AuthenticateResponse{
+		Status:   face_authenticator.AuthenticateStatus_AUTHENTICATE_STATUS_OK,
+		Message:  fmt.Sprintf("%s authenticated with success", req.FaceRequest.Id),
+		Score:    score,
+		Decision: decision,
+	}
+	return authenticateResponse, nil
+}
+
+func (a *Authenticator) getEmbeddings(ctx context.Context, id string) ([]float32, error) {
+	r := cloudevents.NewEvent(cloudevents.VersionV1)
+	r.SetType("get")
+	r.SetSource("authenticator")
+
+	req := &Request{
+		Id: id,
+	}
+	if err := r.SetData("application/json", req); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	newCtx := cloudevents.ContextWithTarget(ctx, a.Target)
+	lookupStart := time.Now()
+	response, res := a.client.Request(newCtx, r)
+	sinkLookupLatencySeconds.Observe(time.Since(lookupStart).Seconds())
+	if cloudevents.IsUndelivered(res) {
+		log.Printf("Failed to request: %v", res)
+		return nil, res
+	} else if response != nil {
+		log.Printf("Got Event Response Context: %+v\n", response.Context)
+	} else {
+		// Parse result
+		log.Printf("Event sent at %s", time.Now())
+		return nil, errors.New("error get embeddings failed")
+	}
+	responseObject := &Response{}
+	if err := response.DataAs(responseObject); err != nil {
+		return nil, errors.New("error parsing response")
+	}
+
+	if len(responseObject.Embeddings) == 0 {
+		return nil, errors.New("got empty embeddings from database")
+	}
+
+	bytes, err := base64.StdEncoding.DecodeString(responseObject.Embeddings)
+	if err != nil {
+		return nil, err
+	}
+	embeddings, err := bytesToFloatArray(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return embeddings, nil
+}
+
+func validRectangle(coordinates *face_authenticator.FaceCoordinates) bool {
+	return coordinates.TopLeft != nil && coordinates.TopLeft.X != 0 && coordinates.TopLeft.Y != 0 && coordinates.BottomRight != nil && coordinates.BottomRight.X != 0 && coordinates.BottomRight.Y != 0
+}
+
+func generateEmbeddings(authent *authenticator.Authenticator, work *work, idThread int) {
+	facereq := work.faceRequest
+	if work.ctx.Err() != nil {
+		return
+	}
+	cImgData := authenticator.Load_mem_jpeg(&facereq.Face[0], len(facereq.Face))
+	defer authenticator.DeleteImage(cImgData)
+	var facePosition authenticator.Rectangle
+	log.Printf("Thread %d: Searching for a face...\n", idThread)
+	if coords := facereq.FaceCoordinates; coords == nil || !validRectangle(coords) {
+		facePosition = (*authent).DetectFace(cImgData)
+		defer authenticator.DeleteRectangle(facePosition)
+	} else {
+		facePosition = authenticator.NewRectangle()
+		facePosition.SetTop(coords.TopLeft.Y)
+		facePosition.SetLeft(coords.TopLeft.X)
+		facePosition.SetBottom(coords.BottomRight.Y)
+		facePosition.SetRight(coords.BottomRight.X)
+	}
+	log.Printf("Thread %d: Found face in area top_left(%d, %d), bottom_right(%d, %d)\n", idThread, facePosition.GetTop(), facePosition.GetLeft(), facePosition.GetBottom(), facePosition.GetRight())
+	if work.ctx.Err() != nil {
+		return
+	}
+	extractedFace := (*authent).ExtractFace(cImgData, facePosition)
+	defer authenticator.DeleteImage(extractedFace)
+	log.Printf("Thread %d: Generating embeddings\n", idThread)
+	if work.ctx.Err() != nil {
+		return
+	}
+	embeddings := (*authent).GenerateEmbeddings(extractedFace)
+	select {
+	case work.responseChannel <- embeddings:
+	case <-work.ctx.Done():
+	}
+}
+
+func bytesToFloatArray(bytes []byte) ([]float32, error) {
+	if len(bytes)%4 != 0 {
+		return nil, errors.New("bytes in input aren't a multiple of 4")
+	}
+	lenArr := len(bytes) / 4
+	array := make([]float32, lenArr)
+	for i := 0; i < lenArr; i++ {
+		array[i] = float32frombytes(bytes[i*4 : (i*4)+4])
+	}
+	return array, nil
+}
+
+func float32frombytes(bytes []byte) float32 {
+	bits := binary.LittleEndian.Uint32(bytes)
+	float := math.Float32frombits(bits)
+	return float
+}